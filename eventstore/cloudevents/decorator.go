@@ -0,0 +1,91 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cloudevents provides an eventhorizon.EventStore decorator that
+// emits every saved event as a CloudEvent to a pluggable sink, letting
+// event-sourced aggregates be bridged into the wider CloudEvents ecosystem
+// without hand-rolling adapters at every call site.
+package cloudevents
+
+import (
+	"log"
+
+	eh "github.com/looplab/eventhorizon"
+	ce "github.com/looplab/eventhorizon/messaging/cloudevents"
+)
+
+// Sink receives CloudEvents produced by the Store decorator. Implementations
+// are expected for HTTP endpoints, files and message brokers such as Kafka.
+type Sink interface {
+	// SendEvent delivers a single CloudEvent to the sink.
+	SendEvent(event *ce.Event) error
+}
+
+// Store decorates an eventhorizon.EventStore, emitting every event it saves
+// to a Sink after the underlying Save succeeds.
+type Store struct {
+	eh.EventStore
+	sink         Sink
+	sourcePrefix ce.SourcePrefix
+}
+
+// NewStore creates a new Store that decorates store and forwards saved
+// events to sink.
+func NewStore(store eh.EventStore, sink Sink) *Store {
+	return &Store{
+		EventStore:   store,
+		sink:         sink,
+		sourcePrefix: ce.DefaultSourcePrefix,
+	}
+}
+
+// SetSourcePrefix sets the prefix used when deriving the CloudEvents source
+// attribute from an event's AggregateType.
+func (s *Store) SetSourcePrefix(prefix ce.SourcePrefix) {
+	s.sourcePrefix = prefix
+}
+
+// Save saves events to the underlying store and then emits each of them as
+// a CloudEvent to the configured sink. Sink delivery happens after the
+// underlying Save has already durably committed the events, so a sink
+// failure is logged rather than returned: returning it here would make the
+// caller believe the save itself failed and retry with the same
+// originalVersion, which would now fail forever against the already-
+// advanced version.
+func (s *Store) Save(events []eh.Event, originalVersion int) error {
+	if err := s.EventStore.Save(events, originalVersion); err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		s.deliver(event)
+	}
+
+	return nil
+}
+
+// deliver encodes event and sends it to the sink, logging rather than
+// propagating any failure, since the event is already saved by the time
+// Save calls this.
+func (s *Store) deliver(event eh.Event) {
+	cloudEvent, err := ce.Encode(event, s.sourcePrefix)
+	if err != nil {
+		log.Printf("eventhorizon: could not encode event for cloudevents sink: %s", err)
+		return
+	}
+
+	if err := s.sink.SendEvent(cloudEvent); err != nil {
+		log.Printf("eventhorizon: could not send event to cloudevents sink: %s", err)
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cloudevents provides encoding and decoding of eventhorizon.Event
+// values to and from the CNCF CloudEvents wire format, together with an HTTP
+// transport for publishing and receiving them.
+package cloudevents
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// SpecVersion is the CloudEvents spec version produced and understood by
+// this package.
+const SpecVersion = "1.0"
+
+// ExtAggregateVersion is the CloudEvents extension attribute that carries
+// the aggregate version of events that implement eventhorizon.Versionable.
+const ExtAggregateVersion = "eh-aggregate-version"
+
+// ErrCouldNotEncodeEvent is when an event could not be encoded to a
+// CloudEvent.
+var ErrCouldNotEncodeEvent = errors.New("could not encode event to cloudevent")
+
+// ErrCouldNotDecodeEvent is when a CloudEvent could not be decoded into an
+// event.
+var ErrCouldNotDecodeEvent = errors.New("could not decode cloudevent to event")
+
+// ErrEventNotRegistered is when no factory has been registered for an
+// event's CloudEvents type.
+var ErrEventNotRegistered = errors.New("event not registered")
+
+// Event is the structured JSON representation of a CloudEvent, as produced
+// from an eventhorizon.Event and consumed back into one.
+type Event struct {
+	SpecVersion     string                 `json:"specversion"`
+	ID              string                 `json:"id"`
+	Type            string                 `json:"type"`
+	Source          string                 `json:"source"`
+	Subject         string                 `json:"subject,omitempty"`
+	Time            string                 `json:"time,omitempty"`
+	DataContentType string                 `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage        `json:"data,omitempty"`
+	Extensions      map[string]interface{} `json:"-"`
+}
+
+// SourcePrefix configures the prefix used when deriving the CloudEvents
+// source from an event's AggregateType, so that the resulting source reads
+// as a URI reference (for example "/aggregates/Invitation").
+type SourcePrefix string
+
+// DefaultSourcePrefix is used when no SourcePrefix is given to Encode.
+const DefaultSourcePrefix SourcePrefix = "/aggregates/"
+
+// Binary HTTP header names for the CloudEvents binary content mode, as
+// defined by the CloudEvents HTTP transport binding spec: core attributes
+// are carried as "ce-*" headers, extension attributes as "ce-<name>"
+// headers, and the body holds only the raw event data with its own
+// Content-Type.
+const (
+	HeaderSpecVersion = "ce-specversion"
+	HeaderID          = "ce-id"
+	HeaderType        = "ce-type"
+	HeaderSource      = "ce-source"
+	HeaderSubject     = "ce-subject"
+	HeaderTime        = "ce-time"
+	headerExtPrefix   = "ce-"
+)
+
+// Encode converts an eventhorizon.Event into its CloudEvents structured
+// representation. AggregateType is mapped to the CloudEvents source (with
+// prefix), AggregateID to the subject and EventType to the type. If the
+// event implements eventhorizon.Versionable its version is carried in the
+// eh-aggregate-version extension attribute.
+func Encode(event eh.Event, prefix SourcePrefix) (*Event, error) {
+	if prefix == "" {
+		prefix = DefaultSourcePrefix
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, ErrCouldNotEncodeEvent
+	}
+
+	ce := &Event{
+		SpecVersion:     SpecVersion,
+		ID:              string(eh.NewUUID()),
+		Type:            string(event.EventType()),
+		Source:          string(prefix) + string(event.AggregateType()),
+		Subject:         string(event.AggregateID()),
+		DataContentType: "application/json",
+		Data:            data,
+	}
+
+	if t, ok := event.(interface{ Timestamp() time.Time }); ok {
+		ce.Time = t.Timestamp().UTC().Format(time.RFC3339Nano)
+	}
+
+	if v, ok := event.(eh.Versionable); ok {
+		ce.Extensions = map[string]interface{}{
+			ExtAggregateVersion: v.AggregateVersion(),
+		}
+	}
+
+	return ce, nil
+}
+
+// MarshalJSON implements json.Marshaler by flattening the CloudEvents
+// extension attributes into the top level of the encoded object, as
+// required by the structured JSON encoding in the CloudEvents spec.
+func (e *Event) MarshalJSON() ([]byte, error) {
+	type alias Event
+	raw, err := json.Marshal((*alias)(e))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(e.Extensions) == 0 {
+		return raw, nil
+	}
+
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+
+	for k, v := range e.Extensions {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = b
+	}
+
+	return json.Marshal(m)
+}
+
+// coreJSONFields are the top-level field names of the structured JSON
+// encoding that aren't extension attributes, as opposed to any other
+// top-level field, which UnmarshalJSON collects into Extensions.
+var coreJSONFields = map[string]bool{
+	"specversion":     true,
+	"id":              true,
+	"type":            true,
+	"source":          true,
+	"subject":         true,
+	"time":            true,
+	"datacontenttype": true,
+	"data":            true,
+}
+
+// UnmarshalJSON implements json.Unmarshaler as the inverse of MarshalJSON:
+// any top-level field that isn't one of the core CloudEvents attributes is
+// collected into Extensions, so a round trip through Encode/MarshalJSON and
+// UnmarshalJSON/Decode doesn't silently drop them.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	type alias Event
+	if err := json.Unmarshal(data, (*alias)(e)); err != nil {
+		return err
+	}
+
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+
+	for k, raw := range m {
+		if coreJSONFields[k] {
+			continue
+		}
+
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		if e.Extensions == nil {
+			e.Extensions = map[string]interface{}{}
+		}
+		e.Extensions[k] = v
+	}
+
+	return nil
+}
+
+// coreBinaryHeaders are the attribute names carried as fixed "ce-*" headers
+// in the binary content mode, as opposed to extension attributes which use
+// the same "ce-<name>" form but are arbitrary.
+var coreBinaryHeaders = map[string]bool{
+	"specversion": true,
+	"id":          true,
+	"type":        true,
+	"source":      true,
+	"subject":     true,
+	"time":        true,
+}
+
+// ExtensionReceiver is implemented by event types that want back whatever
+// CloudEvents extension attributes Encode put on the wire, for example a
+// routing hint added by an intermediary. Decode calls SetExtensions after
+// unmarshaling Data into the event. Events that don't need this, including
+// ones that only get ExtAggregateVersion set on them (that value is already
+// derivable from the event's own fields once Data is unmarshaled), can
+// ignore it.
+type ExtensionReceiver interface {
+	SetExtensions(extensions map[string]interface{})
+}
+
+// Registry maps CloudEvents types to factory functions that create the
+// concrete eventhorizon.Event structs they should be decoded into,
+// mirroring how mongodb.ReadRepository.SetModel resolves concrete models.
+type Registry struct {
+	factories map[string]func() eh.Event
+}
+
+// NewRegistry creates a new empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		factories: map[string]func() eh.Event{},
+	}
+}
+
+// RegisterType registers a factory for a CloudEvents type so that Decode can
+// materialize it.
+func (r *Registry) RegisterType(ceType string, factory func() eh.Event) {
+	r.factories[ceType] = factory
+}
+
+// Decode converts a CloudEvents structured representation back into a
+// concrete eventhorizon.Event, as registered with RegisterType. If ce
+// carries extension attributes and the decoded event implements
+// ExtensionReceiver, they are handed to it via SetExtensions.
+func (r *Registry) Decode(ce *Event) (eh.Event, error) {
+	factory, ok := r.factories[ce.Type]
+	if !ok {
+		return nil, ErrEventNotRegistered
+	}
+
+	event := factory()
+	if len(ce.Data) > 0 {
+		if err := json.Unmarshal(ce.Data, event); err != nil {
+			return nil, ErrCouldNotDecodeEvent
+		}
+	}
+
+	if len(ce.Extensions) > 0 {
+		if er, ok := event.(ExtensionReceiver); ok {
+			er.SetExtensions(ce.Extensions)
+		}
+	}
+
+	return event, nil
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudevents
+
+import (
+	"encoding/json"
+	"testing"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+type testEvent struct {
+	ID      eh.UUID
+	Version int
+}
+
+func (e testEvent) EventType() eh.EventType         { return "TestEvent" }
+func (e testEvent) AggregateID() eh.UUID            { return e.ID }
+func (e testEvent) AggregateType() eh.AggregateType { return "TestAggregate" }
+func (e testEvent) AggregateVersion() int           { return e.Version }
+
+func TestEncodeDecodeStructuredRoundTrip(t *testing.T) {
+	id := eh.NewUUID()
+	event := testEvent{ID: id, Version: 3}
+
+	ce, err := Encode(event, "")
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if ce.Extensions[ExtAggregateVersion] != 3 {
+		t.Fatalf("Extensions[%s] = %v, want 3", ExtAggregateVersion, ce.Extensions[ExtAggregateVersion])
+	}
+
+	body, err := json.Marshal(ce)
+	if err != nil {
+		t.Fatalf("MarshalJSON() error = %v", err)
+	}
+
+	var decoded Event
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("UnmarshalJSON() error = %v", err)
+	}
+
+	if decoded.Type != string(event.EventType()) || decoded.Subject != string(id) {
+		t.Fatalf("decoded = %+v, want type %q subject %q", decoded, event.EventType(), id)
+	}
+
+	gotVersion, ok := decoded.Extensions[ExtAggregateVersion]
+	if !ok {
+		t.Fatalf("Extensions round trip dropped %s, got %+v", ExtAggregateVersion, decoded.Extensions)
+	}
+	// JSON numbers decode as float64 through the generic map[string]interface{}.
+	if gotVersion != float64(3) {
+		t.Errorf("Extensions[%s] = %v, want 3", ExtAggregateVersion, gotVersion)
+	}
+}
+
+type versionReceivingEvent struct {
+	testEvent
+	gotVersion interface{}
+}
+
+func (e *versionReceivingEvent) SetExtensions(extensions map[string]interface{}) {
+	e.gotVersion = extensions[ExtAggregateVersion]
+}
+
+func TestRegistryDecodePassesExtensionsToReceiver(t *testing.T) {
+	event := testEvent{ID: eh.NewUUID(), Version: 5}
+	ce, err := Encode(event, "")
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	registry := NewRegistry()
+	registry.RegisterType("TestEvent", func() eh.Event {
+		return &versionReceivingEvent{}
+	})
+
+	decoded, err := registry.Decode(ce)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	got := decoded.(*versionReceivingEvent)
+	if got.gotVersion != float64(5) {
+		t.Errorf("gotVersion = %v, want 5", got.gotVersion)
+	}
+}
+
+func TestRegistryDecodeUnregisteredType(t *testing.T) {
+	registry := NewRegistry()
+	if _, err := registry.Decode(&Event{Type: "Unknown"}); err != ErrEventNotRegistered {
+		t.Errorf("Decode() error = %v, want %v", err, ErrEventNotRegistered)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudevents
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrCouldNotPublishEvent is when an event could not be delivered to the
+// configured CloudEvents HTTP endpoint.
+var ErrCouldNotPublishEvent = errors.New("could not publish event")
+
+// Publisher publishes eventhorizon.Event values as CloudEvents over HTTP
+// POST requests to a single target endpoint, in either the structured JSON
+// or the binary content mode.
+type Publisher struct {
+	url          string
+	client       *http.Client
+	sourcePrefix SourcePrefix
+	binary       bool
+}
+
+// NewPublisher creates a new Publisher that POSTs events to url.
+func NewPublisher(url string) *Publisher {
+	return &Publisher{
+		url:          url,
+		client:       http.DefaultClient,
+		sourcePrefix: DefaultSourcePrefix,
+	}
+}
+
+// SetHTTPClient sets a custom HTTP client, for example to configure
+// timeouts or TLS settings.
+func (p *Publisher) SetHTTPClient(client *http.Client) {
+	p.client = client
+}
+
+// SetSourcePrefix sets the prefix used when deriving the CloudEvents source
+// attribute from an event's AggregateType.
+func (p *Publisher) SetSourcePrefix(prefix SourcePrefix) {
+	p.sourcePrefix = prefix
+}
+
+// SetBinary toggles the publisher from the default structured JSON content
+// mode to the binary content mode, where CloudEvents attributes are carried
+// as "ce-*" HTTP headers and the body holds only the raw event data.
+func (p *Publisher) SetBinary(binary bool) {
+	p.binary = binary
+}
+
+// PublishEvent encodes event as a CloudEvent, in whichever content mode the
+// publisher is configured for, and POSTs it to the publisher's configured
+// endpoint.
+func (p *Publisher) PublishEvent(event eh.Event) error {
+	ce, err := Encode(event, p.sourcePrefix)
+	if err != nil {
+		return err
+	}
+
+	var req *http.Request
+	if p.binary {
+		req, err = p.newBinaryRequest(ce)
+	} else {
+		req, err = p.newStructuredRequest(ce)
+	}
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return ErrCouldNotPublishEvent
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return ErrCouldNotPublishEvent
+	}
+
+	return nil
+}
+
+// newStructuredRequest builds a request carrying ce as a structured JSON
+// CloudEvent body.
+func (p *Publisher) newStructuredRequest(ce *Event) (*http.Request, error) {
+	body, err := json.Marshal(ce)
+	if err != nil {
+		return nil, ErrCouldNotEncodeEvent
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, ErrCouldNotPublishEvent
+	}
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+
+	return req, nil
+}
+
+// newBinaryRequest builds a request carrying ce's attributes as "ce-*"
+// headers and its raw data as the body, per the CloudEvents binary content
+// mode.
+func (p *Publisher) newBinaryRequest(ce *Event) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(ce.Data))
+	if err != nil {
+		return nil, ErrCouldNotPublishEvent
+	}
+
+	contentType := ce.DataContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(HeaderSpecVersion, ce.SpecVersion)
+	req.Header.Set(HeaderID, ce.ID)
+	req.Header.Set(HeaderType, ce.Type)
+	req.Header.Set(HeaderSource, ce.Source)
+	if ce.Subject != "" {
+		req.Header.Set(HeaderSubject, ce.Subject)
+	}
+	if ce.Time != "" {
+		req.Header.Set(HeaderTime, ce.Time)
+	}
+	for k, v := range ce.Extensions {
+		req.Header.Set("ce-"+k, fmt.Sprint(v))
+	}
+
+	return req, nil
+}
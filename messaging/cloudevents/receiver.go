@@ -0,0 +1,124 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudevents
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ReceiveAdapter is an HTTP handler that ingests inbound structured or
+// binary CloudEvents and dispatches the decoded eventhorizon.Event values onto a
+// local EventBus.
+type ReceiveAdapter struct {
+	bus      eh.EventBus
+	registry *Registry
+}
+
+// NewReceiveAdapter creates a new ReceiveAdapter that publishes decoded
+// events onto bus, using registry to resolve concrete event types.
+func NewReceiveAdapter(bus eh.EventBus, registry *Registry) *ReceiveAdapter {
+	return &ReceiveAdapter{
+		bus:      bus,
+		registry: registry,
+	}
+}
+
+// ServeHTTP implements the http.Handler interface. It supports both the
+// structured JSON CloudEvents content mode and the binary content mode (CE
+// attributes carried as "ce-*" headers with the raw event data as body),
+// telling them apart the same way the CloudEvents HTTP binding spec does:
+// a request carrying a "ce-specversion" header is binary, otherwise it is
+// decoded as structured JSON if its Content-Type is empty or
+// "application/cloudevents+json". Any other Content-Type is rejected with
+// 415 Unsupported Media Type.
+func (a *ReceiveAdapter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var ce *Event
+	var err error
+	if r.Header.Get(HeaderSpecVersion) != "" {
+		ce, err = decodeBinary(r)
+	} else if ct := r.Header.Get("Content-Type"); ct == "" || ct == "application/cloudevents+json" {
+		ce = &Event{}
+		err = json.NewDecoder(r.Body).Decode(ce)
+	} else {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	event, err := a.registry.Decode(ce)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := a.bus.PublishEvent(r.Context(), event); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeBinary reconstructs an Event from a binary content mode request:
+// core attributes come from their fixed "ce-*" headers, any other "ce-*"
+// header becomes an extension attribute, and the body is taken as-is for
+// Data since events already marshal to JSON.
+func decodeBinary(r *http.Request) (*Event, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	ce := &Event{
+		SpecVersion:     r.Header.Get(HeaderSpecVersion),
+		ID:              r.Header.Get(HeaderID),
+		Type:            r.Header.Get(HeaderType),
+		Source:          r.Header.Get(HeaderSource),
+		Subject:         r.Header.Get(HeaderSubject),
+		Time:            r.Header.Get(HeaderTime),
+		DataContentType: r.Header.Get("Content-Type"),
+		Data:            body,
+	}
+
+	for k := range r.Header {
+		lk := strings.ToLower(k)
+		if !strings.HasPrefix(lk, headerExtPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(lk, headerExtPrefix)
+		if coreBinaryHeaders[name] {
+			continue
+		}
+		if ce.Extensions == nil {
+			ce.Extensions = map[string]interface{}{}
+		}
+		ce.Extensions[name] = r.Header.Get(k)
+	}
+
+	return ce, nil
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudevents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+type fakeBus struct {
+	published []eh.Event
+}
+
+func (b *fakeBus) PublishEvent(ctx context.Context, event eh.Event) error {
+	b.published = append(b.published, event)
+	return nil
+}
+
+func (b *fakeBus) AddHandler(handler eh.EventHandler, event eh.Event) {}
+func (b *fakeBus) AddGlobalHandler(handler eh.EventHandler)           {}
+func (b *fakeBus) Close() error                                      { return nil }
+
+func newTestAdapter(t *testing.T, bus *fakeBus) *ReceiveAdapter {
+	t.Helper()
+	registry := NewRegistry()
+	registry.RegisterType("TestEvent", func() eh.Event { return &testEvent{} })
+	return NewReceiveAdapter(bus, registry)
+}
+
+func TestServeHTTPStructuredAndBinaryAgree(t *testing.T) {
+	id := eh.NewUUID()
+	event := testEvent{ID: id, Version: 2}
+	ce, err := Encode(event, "")
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	structuredBus := &fakeBus{}
+	structuredAdapter := newTestAdapter(t, structuredBus)
+	structuredBody, err := json.Marshal(ce)
+	if err != nil {
+		t.Fatalf("marshal structured body: %v", err)
+	}
+	structuredReq := httptest.NewRequest("POST", "/", bytes.NewReader(structuredBody))
+	structuredReq.Header.Set("Content-Type", "application/cloudevents+json")
+	structuredRec := httptest.NewRecorder()
+	structuredAdapter.ServeHTTP(structuredRec, structuredReq)
+
+	if structuredRec.Code != 204 {
+		t.Fatalf("structured ServeHTTP status = %d, body = %s", structuredRec.Code, structuredRec.Body.String())
+	}
+	if len(structuredBus.published) != 1 {
+		t.Fatalf("structured: got %d published events, want 1", len(structuredBus.published))
+	}
+
+	binaryBus := &fakeBus{}
+	binaryAdapter := newTestAdapter(t, binaryBus)
+	binaryReq := httptest.NewRequest("POST", "/", bytes.NewReader(ce.Data))
+	binaryReq.Header.Set("Content-Type", "application/json")
+	binaryReq.Header.Set(HeaderSpecVersion, ce.SpecVersion)
+	binaryReq.Header.Set(HeaderID, ce.ID)
+	binaryReq.Header.Set(HeaderType, ce.Type)
+	binaryReq.Header.Set(HeaderSource, ce.Source)
+	binaryReq.Header.Set(HeaderSubject, ce.Subject)
+	binaryRec := httptest.NewRecorder()
+	binaryAdapter.ServeHTTP(binaryRec, binaryReq)
+
+	if binaryRec.Code != 204 {
+		t.Fatalf("binary ServeHTTP status = %d, body = %s", binaryRec.Code, binaryRec.Body.String())
+	}
+	if len(binaryBus.published) != 1 {
+		t.Fatalf("binary: got %d published events, want 1", len(binaryBus.published))
+	}
+
+	got := binaryBus.published[0].(*testEvent)
+	want := structuredBus.published[0].(*testEvent)
+	if got.ID != want.ID || got.Version != want.Version {
+		t.Errorf("binary decode = %+v, structured decode = %+v, want equal", got, want)
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package redis provides an eventhorizon.EventBus backed by Redis pub/sub,
+// letting multi-process deployments replace an in-process
+// messaging/local.EventBus with a distributed one without changing any
+// domain code.
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/jpillora/backoff"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/messaging/cloudevents"
+)
+
+// DefaultMaxIdle is the default number of idle connections kept in the pool.
+const DefaultMaxIdle = 6
+
+// DefaultIdleTimeout is the default duration after which idle connections
+// are closed, keeping subscribers from being silently dropped by
+// intermediate proxies that reap long-lived idle connections.
+const DefaultIdleTimeout = 240 * time.Second
+
+// EventBus is an eventhorizon.EventBus implementation backed by Redis
+// pub/sub. It mirrors the handler semantics of messaging/local.EventBus:
+// handlers can be registered per event type or globally for every event.
+// Events are serialized with the same codec as messaging/cloudevents, so a
+// Redis bus and an HTTP CloudEvents transport can sit side by side on the
+// same domain events.
+//
+// A single EventBus's handlers receive events published under every
+// namespace, not just namespace: the underlying Redis connection is shared
+// by every tenant on the server, and the subscriber pattern-subscribes to
+// "eh:*" so that PublishEvent under any namespace is delivered (see
+// startSubscriber). namespace therefore only sets the default namespace
+// PublishEvent uses when its context carries none; it is not a per-instance
+// isolation boundary. The namespace an event was published under is carried
+// only in the Redis channel name and is not passed on to handlers.
+type EventBus struct {
+	pool      *redis.Pool
+	namespace string
+	registry  *cloudevents.Registry
+
+	handlersMu     sync.RWMutex
+	handlers       map[eh.EventType][]eh.EventHandler
+	globalHandlers []eh.EventHandler
+
+	subOnce sync.Once
+	done    chan struct{}
+}
+
+// NewEventBus creates a new EventBus connected to the Redis server at addr.
+// namespace is used as the default namespace for PublishEvent when its
+// context carries none; it does not limit which namespaces' events this
+// bus's handlers receive, see the EventBus doc comment. registry is used to
+// decode incoming CloudEvents back into concrete event structs.
+func NewEventBus(addr, namespace string, registry *cloudevents.Registry) *EventBus {
+	pool := &redis.Pool{
+		MaxIdle:     DefaultMaxIdle,
+		IdleTimeout: DefaultIdleTimeout,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr)
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+
+	return &EventBus{
+		pool:      pool,
+		namespace: namespace,
+		registry:  registry,
+		handlers:  map[eh.EventType][]eh.EventHandler{},
+		done:      make(chan struct{}),
+	}
+}
+
+// channel returns the namespaced Redis pub/sub channel for an event type,
+// for example "eh:default:InviteCreated".
+func channel(namespace string, eventType eh.EventType) string {
+	return "eh:" + namespace + ":" + string(eventType)
+}
+
+// AddHandler adds a handler for a specific event type.
+func (b *EventBus) AddHandler(handler eh.EventHandler, event eh.Event) {
+	b.handlersMu.Lock()
+	defer b.handlersMu.Unlock()
+
+	eventType := event.EventType()
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+
+	b.startSubscriber()
+}
+
+// AddGlobalHandler adds a handler that receives every event published on
+// the bus, regardless of event type.
+func (b *EventBus) AddGlobalHandler(handler eh.EventHandler) {
+	b.handlersMu.Lock()
+	defer b.handlersMu.Unlock()
+
+	b.globalHandlers = append(b.globalHandlers, handler)
+
+	b.startSubscriber()
+}
+
+// startSubscriber lazily starts the single reconnecting subscriber
+// goroutine the first time a handler is added. It pattern-subscribes
+// across every namespace, not just the bus's own, since PublishEvent
+// publishes under whatever namespace is carried on its context.
+func (b *EventBus) startSubscriber() {
+	b.subOnce.Do(func() {
+		go b.subscribe("eh:*")
+	})
+}
+
+// PublishEvent publishes event as a CloudEvent on the namespaced channel
+// derived from the context, falling back to the bus's own namespace.
+func (b *EventBus) PublishEvent(ctx context.Context, event eh.Event) error {
+	namespace := eh.NamespaceFromContext(ctx)
+	if namespace == "" {
+		namespace = b.namespace
+	}
+
+	ce, err := cloudevents.Encode(event, "")
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(ce)
+	if err != nil {
+		return eh.ErrCouldNotMarshalEvent
+	}
+
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("PUBLISH", channel(namespace, event.EventType()), data)
+	return err
+}
+
+// subscribe runs a reconnect loop, using jittered exponential backoff, that
+// pattern-subscribes to pattern and fans every published message out to the
+// registered handlers until Close is called, at which point it unsubscribes.
+func (b *EventBus) subscribe(pattern string) {
+	bo := &backoff.Backoff{
+		Min:    100 * time.Millisecond,
+		Max:    30 * time.Second,
+		Jitter: true,
+	}
+
+	for {
+		select {
+		case <-b.done:
+			return
+		default:
+		}
+
+		conn := b.pool.Get()
+		psc := redis.PubSubConn{Conn: conn}
+
+		if err := psc.PSubscribe(pattern); err != nil {
+			conn.Close()
+			time.Sleep(bo.Duration())
+			continue
+		}
+		bo.Reset()
+
+		// Unsubscribe as soon as Close is called, which also wakes receive
+		// up from a blocking Receive.
+		stop := make(chan struct{})
+		go func() {
+			select {
+			case <-b.done:
+				psc.PUnsubscribe()
+			case <-stop:
+			}
+		}()
+
+		b.receive(psc)
+		close(stop)
+		conn.Close()
+
+		select {
+		case <-b.done:
+			return
+		case <-time.After(bo.Duration()):
+		}
+	}
+}
+
+// receive reads messages off psc until it errors or Close is called.
+func (b *EventBus) receive(psc redis.PubSubConn) {
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.PMessage:
+			b.handle(v.Data)
+		case error:
+			return
+		}
+
+		select {
+		case <-b.done:
+			return
+		default:
+		}
+	}
+}
+
+// handle decodes a raw CloudEvent message and dispatches it to the
+// handlers registered for its event type, plus every global handler.
+func (b *EventBus) handle(data []byte) {
+	var ce cloudevents.Event
+	if err := json.Unmarshal(data, &ce); err != nil {
+		log.Printf("eventhorizon: could not unmarshal event from redis: %s", err)
+		return
+	}
+
+	event, err := b.registry.Decode(&ce)
+	if err != nil {
+		log.Printf("eventhorizon: could not decode event from redis: %s", err)
+		return
+	}
+
+	b.handlersMu.RLock()
+	handlers := append([]eh.EventHandler{}, b.handlers[event.EventType()]...)
+	globalHandlers := append([]eh.EventHandler{}, b.globalHandlers...)
+	b.handlersMu.RUnlock()
+
+	for _, h := range handlers {
+		h.HandleEvent(event)
+	}
+	for _, h := range globalHandlers {
+		h.HandleEvent(event)
+	}
+}
+
+// Close unsubscribes all channels and closes the underlying connection
+// pool.
+func (b *EventBus) Close() error {
+	close(b.done)
+	return b.pool.Close()
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package replication continuously copies events from a source
+// eventhorizon.EventStore to one or more target event stores, so that
+// cross-region DR and blue/green migrations of event stores don't require
+// ad-hoc scripts.
+package replication
+
+import (
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Position identifies a point in a source store's global event stream that
+// replication has progressed to.
+type Position int64
+
+// Policy describes what to replicate, where from, where to and when.
+type Policy struct {
+	ID eh.UUID
+
+	// Name identifies the policy for operators.
+	Name string
+
+	// SourceNamespace restricts replication to events saved under this
+	// namespace. Not every Source can honor this: one that can't implements
+	// NamespaceScoper returning false (or doesn't implement it at all), and
+	// Replicator.AddPolicy/TriggerNow reject a non-empty SourceNamespace
+	// against it rather than silently replicating every namespace.
+	SourceNamespace string
+
+	// AggregateTypes filters which aggregate types are replicated. An
+	// empty slice replicates every aggregate type.
+	AggregateTypes []eh.AggregateType
+
+	// TargetID is the ID of the Target this policy replicates into.
+	TargetID eh.UUID
+
+	// Enabled controls whether TriggerNow or the continuous loop will run
+	// this policy.
+	Enabled bool
+
+	// Schedule is a cron expression describing when to run the policy. An
+	// empty Schedule means the policy replicates continuously.
+	Schedule string
+
+	// Position is the last position successfully replicated to the
+	// target, used to resume after a restart.
+	Position Position
+}
+
+// Target describes a destination event store that one or more policies
+// replicate into.
+type Target struct {
+	ID eh.UUID
+
+	// Name identifies the target for operators.
+	Name string
+
+	// Description is a free-form human readable note about the target.
+	Description string
+
+	// Connection is the connection string or URL used to reach the
+	// target store.
+	Connection string
+
+	// Credentials holds any secret needed to authenticate with the
+	// target, for example an access key or password.
+	Credentials string
+}
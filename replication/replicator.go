@@ -0,0 +1,358 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replication
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrPolicyNotFound is when no policy could be found for an ID.
+var ErrPolicyNotFound = errors.New("policy not found")
+
+// ErrTargetNotFound is when no target could be found for an ID.
+var ErrTargetNotFound = errors.New("target not found")
+
+// ErrTargetNotRegistered is when a policy's target has metadata but no
+// live TargetWriter has been registered for it.
+var ErrTargetNotRegistered = errors.New("target not registered")
+
+// ErrSourceNamespaceUnsupported is when a policy sets SourceNamespace but
+// the Replicator's Source can't actually restrict LoadFrom to one
+// namespace.
+var ErrSourceNamespaceUnsupported = errors.New("source does not support namespace-scoped replication")
+
+// pollInterval is how often a continuously triggered policy polls the
+// source for new events once it has caught up.
+const pollInterval = time.Second
+
+// batchSize is the maximum number of events loaded from the source per
+// replication round.
+const batchSize = 256
+
+// Source is a source event store that can be tailed from a given Position,
+// for example by exposing a cursor over its underlying storage.
+// storage/etcd.EventStore implements Source using etcd's own mvcc
+// modification order as the cursor.
+type Source interface {
+	// LoadFrom returns up to limit events stored after position, together
+	// with the Position to resume from on the next call.
+	LoadFrom(namespace string, position Position, limit int) (events []eh.Event, next Position, err error)
+}
+
+// TargetWriter appends events to a target event store. Writes must be
+// idempotent keyed on (AggregateID, Version) so that re-running a policy
+// after a crash is safe. storage/etcd.EventStore implements TargetWriter by
+// writing each event under its own AggregateVersion.
+type TargetWriter interface {
+	Replicate(events []eh.Event) error
+}
+
+// NamespaceScoper is implemented by a Source that can actually restrict
+// LoadFrom to a single namespace. A Source that accepts the namespace
+// argument only for interface compatibility, such as storage/etcd's, should
+// not implement it, so that AddPolicy and TriggerNow can reject a
+// namespace-scoped Policy against it instead of silently replicating every
+// namespace.
+type NamespaceScoper interface {
+	ScopesNamespace() bool
+}
+
+// Status describes the current state of a policy's replication.
+type Status struct {
+	Policy  Policy
+	Running bool
+}
+
+// ReplicationStarted is emitted on the lifecycle bus when a policy begins
+// its continuous replication loop.
+type ReplicationStarted struct {
+	PolicyID eh.UUID
+}
+
+// EventType implements the eventhorizon.Event interface.
+func (e ReplicationStarted) EventType() eh.EventType { return "ReplicationStarted" }
+
+// ReplicationStopped is emitted on the lifecycle bus when a policy's
+// replication loop is stopped, either via RemovePolicy or Close.
+type ReplicationStopped struct {
+	PolicyID eh.UUID
+}
+
+// EventType implements the eventhorizon.Event interface.
+func (e ReplicationStopped) EventType() eh.EventType { return "ReplicationStopped" }
+
+// ReplicationFailed is emitted on the lifecycle bus when a replication
+// round fails. The loop keeps retrying after emitting this event.
+type ReplicationFailed struct {
+	PolicyID eh.UUID
+	Err      string
+}
+
+// EventType implements the eventhorizon.Event interface.
+func (e ReplicationFailed) EventType() eh.EventType { return "ReplicationFailed" }
+
+// Replicator continuously copies events from a Source to one or more
+// registered Targets, as described by a set of Policy objects. Policy and
+// Target metadata, including each policy's last-replicated Position, is
+// persisted in a ReadWriteRepo so it works unmodified with both
+// storage/memory and storage/mongodb read repositories.
+type Replicator struct {
+	source   Source
+	policies eh.ReadWriteRepo
+	targets  eh.ReadWriteRepo
+	bus      eh.EventBus
+
+	mu      sync.Mutex
+	writers map[eh.UUID]TargetWriter
+	running map[eh.UUID]chan struct{}
+}
+
+// NewReplicator creates a new Replicator that tails source and persists
+// policy/target metadata in policies and targets. Lifecycle events are
+// published on bus.
+func NewReplicator(source Source, policies, targets eh.ReadWriteRepo, bus eh.EventBus) *Replicator {
+	return &Replicator{
+		source:   source,
+		policies: policies,
+		targets:  targets,
+		bus:      bus,
+		writers:  map[eh.UUID]TargetWriter{},
+		running:  map[eh.UUID]chan struct{}{},
+	}
+}
+
+// RegisterTarget associates a live TargetWriter with a Target's metadata
+// and persists the metadata, mirroring how a ReadRepository factory is
+// registered alongside its concrete storage connection.
+func (r *Replicator) RegisterTarget(target *Target, writer TargetWriter) error {
+	if err := r.targets.Save(target.ID, target); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.writers[target.ID] = writer
+	r.mu.Unlock()
+
+	return nil
+}
+
+// AddPolicy persists policy and, if Enabled and its Schedule is empty,
+// starts its continuous replication loop in the background. It returns
+// ErrSourceNamespaceUnsupported if policy sets SourceNamespace but the
+// Replicator's Source can't honor it.
+func (r *Replicator) AddPolicy(policy *Policy) error {
+	if err := r.checkSourceNamespace(policy); err != nil {
+		return err
+	}
+
+	if err := r.policies.Save(policy.ID, policy); err != nil {
+		return err
+	}
+
+	if policy.Enabled && policy.Schedule == "" {
+		r.start(policy)
+	}
+
+	return nil
+}
+
+// checkSourceNamespace rejects a namespace-scoped policy whose Source can't
+// actually restrict LoadFrom to that namespace, per NamespaceScoper.
+func (r *Replicator) checkSourceNamespace(policy *Policy) error {
+	if policy.SourceNamespace == "" {
+		return nil
+	}
+
+	scoper, ok := r.source.(NamespaceScoper)
+	if !ok || !scoper.ScopesNamespace() {
+		return ErrSourceNamespaceUnsupported
+	}
+
+	return nil
+}
+
+// RemovePolicy stops a running policy, if any, and removes its metadata.
+func (r *Replicator) RemovePolicy(id eh.UUID) error {
+	r.stop(id)
+	return r.policies.Remove(id)
+}
+
+// TriggerNow runs a single replication round for policy id immediately,
+// regardless of its Schedule. It is safe to call while the policy's
+// continuous loop is also running.
+func (r *Replicator) TriggerNow(id eh.UUID) error {
+	policy, err := r.policy(id)
+	if err != nil {
+		return err
+	}
+
+	if err := r.checkSourceNamespace(policy); err != nil {
+		return err
+	}
+
+	return r.replicateOnce(policy)
+}
+
+// Status returns the persisted policy and whether its continuous loop is
+// currently running.
+func (r *Replicator) Status(id eh.UUID) (Status, error) {
+	policy, err := r.policy(id)
+	if err != nil {
+		return Status{}, err
+	}
+
+	r.mu.Lock()
+	_, running := r.running[id]
+	r.mu.Unlock()
+
+	return Status{Policy: *policy, Running: running}, nil
+}
+
+// Close stops every running continuous replication loop.
+func (r *Replicator) Close() {
+	r.mu.Lock()
+	ids := make([]eh.UUID, 0, len(r.running))
+	for id := range r.running {
+		ids = append(ids, id)
+	}
+	r.mu.Unlock()
+
+	for _, id := range ids {
+		r.stop(id)
+	}
+}
+
+func (r *Replicator) policy(id eh.UUID) (*Policy, error) {
+	model, err := r.policies.Find(id)
+	if err != nil {
+		return nil, ErrPolicyNotFound
+	}
+
+	policy, ok := model.(*Policy)
+	if !ok {
+		return nil, ErrPolicyNotFound
+	}
+
+	return policy, nil
+}
+
+func (r *Replicator) start(policy *Policy) {
+	r.mu.Lock()
+	if _, ok := r.running[policy.ID]; ok {
+		r.mu.Unlock()
+		return
+	}
+	done := make(chan struct{})
+	r.running[policy.ID] = done
+	r.mu.Unlock()
+
+	go r.run(policy.ID, done)
+}
+
+func (r *Replicator) stop(id eh.UUID) {
+	r.mu.Lock()
+	done, ok := r.running[id]
+	if ok {
+		delete(r.running, id)
+	}
+	r.mu.Unlock()
+
+	if ok {
+		close(done)
+	}
+}
+
+func (r *Replicator) run(id eh.UUID, done chan struct{}) {
+	if r.bus != nil {
+		r.bus.PublishEvent(context.Background(), ReplicationStarted{PolicyID: id})
+	}
+
+	for {
+		select {
+		case <-done:
+			if r.bus != nil {
+				r.bus.PublishEvent(context.Background(), ReplicationStopped{PolicyID: id})
+			}
+			return
+		default:
+		}
+
+		policy, err := r.policy(id)
+		if err != nil {
+			return
+		}
+
+		if err := r.replicateOnce(policy); err != nil && r.bus != nil {
+			r.bus.PublishEvent(context.Background(), ReplicationFailed{PolicyID: id, Err: err.Error()})
+		}
+
+		select {
+		case <-done:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// replicateOnce loads one batch of events from the source starting at the
+// policy's last Position, filters them by AggregateTypes, appends them to
+// the policy's target and advances the persisted Position.
+func (r *Replicator) replicateOnce(policy *Policy) error {
+	events, next, err := r.source.LoadFrom(policy.SourceNamespace, policy.Position, batchSize)
+	if err != nil {
+		return err
+	}
+
+	filtered := filterByAggregateType(events, policy.AggregateTypes)
+	if len(filtered) > 0 {
+		r.mu.Lock()
+		writer, ok := r.writers[policy.TargetID]
+		r.mu.Unlock()
+		if !ok {
+			return ErrTargetNotRegistered
+		}
+
+		if err := writer.Replicate(filtered); err != nil {
+			return err
+		}
+	}
+
+	policy.Position = next
+	return r.policies.Save(policy.ID, policy)
+}
+
+func filterByAggregateType(events []eh.Event, types []eh.AggregateType) []eh.Event {
+	if len(types) == 0 {
+		return events
+	}
+
+	allowed := map[eh.AggregateType]bool{}
+	for _, t := range types {
+		allowed[t] = true
+	}
+
+	filtered := make([]eh.Event, 0, len(events))
+	for _, event := range events {
+		if allowed[event.AggregateType()] {
+			filtered = append(filtered, event)
+		}
+	}
+
+	return filtered
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replication
+
+import (
+	"testing"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+type filterTestEvent struct {
+	aggregateType eh.AggregateType
+}
+
+func (e filterTestEvent) EventType() eh.EventType         { return "FilterTestEvent" }
+func (e filterTestEvent) AggregateID() eh.UUID            { return eh.UUID("") }
+func (e filterTestEvent) AggregateType() eh.AggregateType { return e.aggregateType }
+
+func TestFilterByAggregateTypeNoTypesReturnsAllEvents(t *testing.T) {
+	events := []eh.Event{
+		filterTestEvent{aggregateType: "Invitation"},
+		filterTestEvent{aggregateType: "Account"},
+	}
+
+	filtered := filterByAggregateType(events, nil)
+
+	if len(filtered) != len(events) {
+		t.Fatalf("filterByAggregateType() returned %d events, want %d", len(filtered), len(events))
+	}
+}
+
+func TestFilterByAggregateTypeKeepsOnlyAllowedTypes(t *testing.T) {
+	events := []eh.Event{
+		filterTestEvent{aggregateType: "Invitation"},
+		filterTestEvent{aggregateType: "Account"},
+		filterTestEvent{aggregateType: "Invitation"},
+	}
+
+	filtered := filterByAggregateType(events, []eh.AggregateType{"Invitation"})
+
+	if len(filtered) != 2 {
+		t.Fatalf("filterByAggregateType() returned %d events, want 2", len(filtered))
+	}
+	for _, event := range filtered {
+		if event.AggregateType() != "Invitation" {
+			t.Errorf("filtered event has AggregateType %q, want %q", event.AggregateType(), "Invitation")
+		}
+	}
+}
+
+func TestFilterByAggregateTypeNoMatchesReturnsEmpty(t *testing.T) {
+	events := []eh.Event{filterTestEvent{aggregateType: "Account"}}
+
+	filtered := filterByAggregateType(events, []eh.AggregateType{"Invitation"})
+
+	if len(filtered) != 0 {
+		t.Fatalf("filterByAggregateType() returned %d events, want 0", len(filtered))
+	}
+}
+
+type scopingSource struct{ scopes bool }
+
+func (s scopingSource) LoadFrom(namespace string, position Position, limit int) ([]eh.Event, Position, error) {
+	return nil, position, nil
+}
+
+func (s scopingSource) ScopesNamespace() bool { return s.scopes }
+
+type unscopedSource struct{}
+
+func (s unscopedSource) LoadFrom(namespace string, position Position, limit int) ([]eh.Event, Position, error) {
+	return nil, position, nil
+}
+
+func TestCheckSourceNamespaceAllowsUnscopedPolicy(t *testing.T) {
+	r := NewReplicator(unscopedSource{}, nil, nil, nil)
+
+	if err := r.checkSourceNamespace(&Policy{}); err != nil {
+		t.Errorf("checkSourceNamespace() error = %v, want nil", err)
+	}
+}
+
+func TestCheckSourceNamespaceRejectsUnsupportedSource(t *testing.T) {
+	r := NewReplicator(unscopedSource{}, nil, nil, nil)
+
+	err := r.checkSourceNamespace(&Policy{SourceNamespace: "tenant-a"})
+	if err != ErrSourceNamespaceUnsupported {
+		t.Errorf("checkSourceNamespace() error = %v, want %v", err, ErrSourceNamespaceUnsupported)
+	}
+}
+
+func TestCheckSourceNamespaceRejectsNonScopingSource(t *testing.T) {
+	r := NewReplicator(scopingSource{scopes: false}, nil, nil, nil)
+
+	err := r.checkSourceNamespace(&Policy{SourceNamespace: "tenant-a"})
+	if err != ErrSourceNamespaceUnsupported {
+		t.Errorf("checkSourceNamespace() error = %v, want %v", err, ErrSourceNamespaceUnsupported)
+	}
+}
+
+func TestCheckSourceNamespaceAllowsScopingSource(t *testing.T) {
+	r := NewReplicator(scopingSource{scopes: true}, nil, nil, nil)
+
+	if err := r.checkSourceNamespace(&Policy{SourceNamespace: "tenant-a"}); err != nil {
+		t.Errorf("checkSourceNamespace() error = %v, want nil", err)
+	}
+}
@@ -87,8 +87,20 @@ func (r *Repo) Find(ctx context.Context, id eh.UUID) (interface{}, error) {
 	}
 }
 
+// minVersionFinder is implemented by read repositories that can resolve a
+// minimum version check server-side, such as mongodb.ReadRepository's
+// FindMinVersion, instead of fetching the model and checking its version
+// in Go on every retry.
+type minVersionFinder interface {
+	FindMinVersion(ctx context.Context, id eh.UUID, minVersion int) (interface{}, error)
+}
+
 // findMinVersion finds an item if it has a version and it is at least minVersion.
 func (r *Repo) findMinVersion(ctx context.Context, id eh.UUID, minVersion int) (interface{}, error) {
+	if finder, ok := r.ReadWriteRepo.(minVersionFinder); ok {
+		return finder.FindMinVersion(ctx, id, minVersion)
+	}
+
 	model, err := r.ReadWriteRepo.Find(ctx, id)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,147 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/coreos/etcd/clientv3"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// EventBus is an eventhorizon.EventBus implementation that watches the
+// same etcd key range an EventStore writes its events to, emitting every
+// new event key as a bus message. It lets a single etcd cluster back both
+// persistence and pub/sub, analogous to how the delegation example uses
+// one process for both memory.NewEventStore and local.NewEventBus.
+type EventBus struct {
+	client  *clientv3.Client
+	prefix  string
+	factory map[eh.EventType]func() eh.Event
+
+	handlersMu     sync.RWMutex
+	handlers       map[eh.EventType][]eh.EventHandler
+	globalHandlers []eh.EventHandler
+
+	cancel context.CancelFunc
+}
+
+// NewEventBus creates a new EventBus that watches the etcd key range under
+// prefix, as written to by an EventStore sharing the same prefix.
+func NewEventBus(client *clientv3.Client, prefix string) *EventBus {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	b := &EventBus{
+		client:   client,
+		prefix:   prefix,
+		factory:  map[eh.EventType]func() eh.Event{},
+		handlers: map[eh.EventType][]eh.EventHandler{},
+		cancel:   cancel,
+	}
+
+	go b.watch(ctx)
+
+	return b
+}
+
+// RegisterEventType registers a factory used to create a concrete event
+// struct for events seen on the watch, mirroring EventStore.RegisterEventType.
+func (b *EventBus) RegisterEventType(eventType eh.EventType, factory func() eh.Event) {
+	b.factory[eventType] = factory
+}
+
+// AddHandler adds a handler for a specific event type.
+func (b *EventBus) AddHandler(handler eh.EventHandler, event eh.Event) {
+	b.handlersMu.Lock()
+	defer b.handlersMu.Unlock()
+
+	eventType := event.EventType()
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+// AddGlobalHandler adds a handler that receives every event published on
+// the bus.
+func (b *EventBus) AddGlobalHandler(handler eh.EventHandler) {
+	b.handlersMu.Lock()
+	defer b.handlersMu.Unlock()
+
+	b.globalHandlers = append(b.globalHandlers, handler)
+}
+
+// PublishEvent is a no-op for EventBus: events are observed via the etcd
+// watch as an EventStore sharing the same prefix saves them, so there is
+// nothing left to publish explicitly.
+func (b *EventBus) PublishEvent(ctx context.Context, event eh.Event) error {
+	return nil
+}
+
+// watch tails every put under prefix and dispatches the decoded events to
+// the registered handlers until ctx is cancelled by Close.
+func (b *EventBus) watch(ctx context.Context) {
+	watchChan := b.client.Watch(ctx, b.prefix, clientv3.WithPrefix())
+
+	for resp := range watchChan {
+		for _, ev := range resp.Events {
+			if ev.Type != clientv3.EventTypePut {
+				continue
+			}
+			b.handle(ev.Kv.Value)
+		}
+	}
+}
+
+func (b *EventBus) handle(data []byte) {
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		// Not every key under prefix is an event record (for example the
+		// "_version" and "_index" bookkeeping keys), so silently skip
+		// anything that doesn't decode as one.
+		return
+	}
+
+	factory, ok := b.factory[rec.Type]
+	if !ok {
+		log.Printf("eventhorizon: no event type registered for %s", rec.Type)
+		return
+	}
+
+	event := factory()
+	if err := json.Unmarshal(rec.Data, event); err != nil {
+		log.Printf("eventhorizon: could not unmarshal event from etcd watch: %s", err)
+		return
+	}
+
+	b.handlersMu.RLock()
+	handlers := append([]eh.EventHandler{}, b.handlers[event.EventType()]...)
+	globalHandlers := append([]eh.EventHandler{}, b.globalHandlers...)
+	b.handlersMu.RUnlock()
+
+	for _, h := range handlers {
+		h.HandleEvent(event)
+	}
+	for _, h := range globalHandlers {
+		h.HandleEvent(event)
+	}
+}
+
+// Close stops the background watch.
+func (b *EventBus) Close() error {
+	b.cancel()
+	return nil
+}
@@ -0,0 +1,227 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package etcd provides an eventhorizon.EventStore backed by etcd, along
+// with a lease-based AggregateLock and a watch-based EventBus, so a single
+// etcd cluster can back both persistence and pub/sub for smaller
+// deployments.
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/coreos/etcd/clientv3"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// DefaultPrefix is the default etcd key prefix events are stored under.
+const DefaultPrefix = "/eh/events"
+
+// ErrConcurrentSave is when a Save failed because the aggregate was
+// modified concurrently by another process between Load and Save.
+var ErrConcurrentSave = errors.New("could not save events: concurrent modification")
+
+// ErrNoEventsToAppend is when Save is called with no events.
+var ErrNoEventsToAppend = errors.New("no events to append")
+
+// ErrCouldNotMarshalEvent is when an event could not be marshaled to JSON.
+var ErrCouldNotMarshalEvent = errors.New("could not marshal event")
+
+// ErrCouldNotUnmarshalEvent is when an event could not be unmarshaled from
+// JSON stored in etcd.
+var ErrCouldNotUnmarshalEvent = errors.New("could not unmarshal event")
+
+// ErrEventNotRegistered is when no factory has been registered for a
+// stored event's type.
+var ErrEventNotRegistered = errors.New("event not registered")
+
+// record is the envelope stored for each event key, carrying the concrete
+// event type alongside its JSON payload so Load can resolve the right
+// factory to unmarshal into.
+type record struct {
+	Type eh.EventType    `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// EventStore implements eventhorizon.EventStore backed by etcd. Each
+// aggregate's events are stored under keys of the form
+// "{prefix}/{aggregateType}/{aggregateID}/{version}", with a sibling
+// "_version" key holding the aggregate's current version and an
+// "{prefix}/_index/{aggregateID}" key recording its aggregate type so that
+// Load can find it back from just an ID. Save uses an etcd mvcc
+// transaction against the version key to give strong optimistic
+// concurrency without needing a separate database.
+type EventStore struct {
+	client  *clientv3.Client
+	prefix  string
+	bus     eh.EventBus
+	factory map[eh.EventType]func() eh.Event
+}
+
+// NewEventStore creates a new EventStore using client, publishing saved
+// events on bus. bus may be nil if no publishing is needed.
+func NewEventStore(client *clientv3.Client, bus eh.EventBus) *EventStore {
+	return &EventStore{
+		client:  client,
+		prefix:  DefaultPrefix,
+		bus:     bus,
+		factory: map[eh.EventType]func() eh.Event{},
+	}
+}
+
+// SetPrefix overrides the default etcd key prefix events are stored under.
+func (s *EventStore) SetPrefix(prefix string) {
+	s.prefix = prefix
+}
+
+// RegisterEventType registers a factory used to create a concrete event
+// struct for eventType, so that Load can unmarshal the JSON stored under a
+// record back into the right Go type.
+func (s *EventStore) RegisterEventType(eventType eh.EventType, factory func() eh.Event) {
+	s.factory[eventType] = factory
+}
+
+func (s *EventStore) indexKey(id eh.UUID) string {
+	return fmt.Sprintf("%s/_index/%s", s.prefix, id)
+}
+
+func (s *EventStore) versionKey(aggregateType eh.AggregateType, id eh.UUID) string {
+	return fmt.Sprintf("%s/%s/%s/_version", s.prefix, aggregateType, id)
+}
+
+// versionWidth is the zero-padded width of the version segment in an event
+// key, so that etcd's byte-lexicographic key sort (used by Load) agrees
+// with numeric version order.
+const versionWidth = 20
+
+func (s *EventStore) eventKey(aggregateType eh.AggregateType, id eh.UUID, version int) string {
+	return fmt.Sprintf("%s/%s/%s/%0*d", s.prefix, aggregateType, id, versionWidth, version)
+}
+
+// Save appends events to the aggregate they belong to, failing with
+// ErrConcurrentSave if the aggregate's version has advanced past
+// originalVersion since it was loaded. Publishing to bus happens after the
+// etcd transaction has already committed, so a publish failure is logged
+// rather than returned: returning it here would make a caller believe the
+// save itself failed and retry with the same originalVersion, which would
+// now fail forever against the already-advanced version.
+func (s *EventStore) Save(events []eh.Event, originalVersion int) error {
+	if len(events) == 0 {
+		return ErrNoEventsToAppend
+	}
+
+	aggregateID := events[0].AggregateID()
+	aggregateType := events[0].AggregateType()
+
+	ops := make([]clientv3.Op, 0, len(events)+2)
+	version := originalVersion
+	for _, event := range events {
+		version++
+
+		data, err := json.Marshal(event)
+		if err != nil {
+			return ErrCouldNotMarshalEvent
+		}
+		rec, err := json.Marshal(record{Type: event.EventType(), Data: data})
+		if err != nil {
+			return ErrCouldNotMarshalEvent
+		}
+
+		ops = append(ops, clientv3.OpPut(s.eventKey(aggregateType, aggregateID, version), string(rec)))
+	}
+	ops = append(ops,
+		clientv3.OpPut(s.versionKey(aggregateType, aggregateID), strconv.Itoa(version)),
+		clientv3.OpPut(s.indexKey(aggregateID), string(aggregateType)),
+	)
+
+	versionKey := s.versionKey(aggregateType, aggregateID)
+	var cmp clientv3.Cmp
+	if originalVersion == 0 {
+		cmp = clientv3.Compare(clientv3.CreateRevision(versionKey), "=", 0)
+	} else {
+		cmp = clientv3.Compare(clientv3.Value(versionKey), "=", strconv.Itoa(originalVersion))
+	}
+
+	resp, err := s.client.Txn(context.Background()).If(cmp).Then(ops...).Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return ErrConcurrentSave
+	}
+
+	if s.bus != nil {
+		for _, event := range events {
+			if err := s.bus.PublishEvent(context.Background(), event); err != nil {
+				log.Printf("eventhorizon: could not publish event from etcd event store: %s", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Load returns all events for the aggregate with id, in version order.
+func (s *EventStore) Load(id eh.UUID) ([]eh.Event, error) {
+	ctx := context.Background()
+
+	indexResp, err := s.client.Get(ctx, s.indexKey(id))
+	if err != nil {
+		return nil, err
+	}
+	if len(indexResp.Kvs) == 0 {
+		return []eh.Event{}, nil
+	}
+	aggregateType := eh.AggregateType(indexResp.Kvs[0].Value)
+
+	prefix := fmt.Sprintf("%s/%s/%s/", s.prefix, aggregateType, id)
+	resp, err := s.client.Get(ctx, prefix,
+		clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]eh.Event, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		if string(kv.Key) == prefix+"_version" {
+			continue
+		}
+
+		var rec record
+		if err := json.Unmarshal(kv.Value, &rec); err != nil {
+			return nil, ErrCouldNotUnmarshalEvent
+		}
+
+		factory, ok := s.factory[rec.Type]
+		if !ok {
+			return nil, ErrEventNotRegistered
+		}
+
+		event := factory()
+		if err := json.Unmarshal(rec.Data, event); err != nil {
+			return nil, ErrCouldNotUnmarshalEvent
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
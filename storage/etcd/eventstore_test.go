@@ -0,0 +1,63 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"sort"
+	"testing"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+func TestEventKeyOrdersLexicographicallyByVersion(t *testing.T) {
+	s := &EventStore{prefix: DefaultPrefix}
+	id := eh.UUID("aggregate-1")
+
+	versions := []int{1, 2, 9, 10, 11, 25, 100}
+	keys := make([]string, len(versions))
+	for i, v := range versions {
+		keys[i] = s.eventKey("Invitation", id, v)
+	}
+
+	sorted := make([]string, len(keys))
+	copy(sorted, keys)
+	sort.Strings(sorted)
+
+	for i := range keys {
+		if keys[i] != sorted[i] {
+			t.Fatalf("eventKey keys are not in lexicographic version order: got %v, want %v", keys, sorted)
+		}
+	}
+}
+
+func TestIsEventKey(t *testing.T) {
+	s := &EventStore{prefix: DefaultPrefix}
+	id := eh.UUID("aggregate-1")
+
+	cases := []struct {
+		key  string
+		want bool
+	}{
+		{s.eventKey("Invitation", id, 1), true},
+		{s.versionKey("Invitation", id), false},
+		{s.indexKey(id), false},
+	}
+
+	for _, c := range cases {
+		if got := isEventKey(c.key); got != c.want {
+			t.Errorf("isEventKey(%q) = %v, want %v", c.key, got, c.want)
+		}
+	}
+}
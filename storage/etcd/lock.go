@@ -0,0 +1,127 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// DefaultLockPrefix is the default etcd key prefix aggregate locks are
+// acquired under.
+const DefaultLockPrefix = "/eh/locks"
+
+// DefaultLeaseTTL is the lease TTL a LeaseLock keeps alive in the
+// background for as long as it is held.
+const DefaultLeaseTTL = 10 * time.Second
+
+// ErrLockHeldByAnother is when a lock could not be acquired because
+// another process already holds it.
+var ErrLockHeldByAnother = errors.New("lock held by another process")
+
+// LeaseLock is an eventhorizon.AggregateLock implementation backed by an
+// etcd lease. It lets an AggregateCommandHandler optionally serialize
+// command handling per aggregate across processes.
+type LeaseLock struct {
+	client *clientv3.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// NewLeaseLock creates a new LeaseLock using client.
+func NewLeaseLock(client *clientv3.Client) *LeaseLock {
+	return &LeaseLock{
+		client: client,
+		prefix: DefaultLockPrefix,
+		ttl:    DefaultLeaseTTL,
+	}
+}
+
+// SetPrefix overrides the default etcd key prefix locks are acquired
+// under.
+func (l *LeaseLock) SetPrefix(prefix string) {
+	l.prefix = prefix
+}
+
+// SetLeaseTTL overrides the default lease TTL.
+func (l *LeaseLock) SetLeaseTTL(ttl time.Duration) {
+	l.ttl = ttl
+}
+
+// Lock is a held lock for a single aggregate, returned by LeaseLock.Acquire.
+// Its lease is kept alive in the background until Close is called.
+type Lock struct {
+	client *clientv3.Client
+	lease  clientv3.LeaseID
+	cancel context.CancelFunc
+}
+
+// Acquire acquires the lock for aggregateID, failing with
+// ErrLockHeldByAnother if another process already holds it.
+func (l *LeaseLock) Acquire(aggregateID eh.UUID) (*Lock, error) {
+	ctx := context.Background()
+
+	lease, err := l.client.Grant(ctx, int64(l.ttl/time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("%s/%s", l.prefix, aggregateID)
+	txnResp, err := l.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, string(aggregateID), clientv3.WithLease(lease.ID))).
+		Commit()
+	if err != nil {
+		return nil, err
+	}
+	if !txnResp.Succeeded {
+		if _, err := l.client.Revoke(ctx, lease.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrLockHeldByAnother
+	}
+
+	keepAliveCtx, cancel := context.WithCancel(context.Background())
+	keepAlive, err := l.client.KeepAlive(keepAliveCtx, lease.ID)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go func() {
+		for range keepAlive {
+			// Drain keep-alive responses for as long as the lock is held.
+		}
+	}()
+
+	return &Lock{
+		client: l.client,
+		lease:  lease.ID,
+		cancel: cancel,
+	}, nil
+}
+
+// Close releases the lock by revoking its lease and stops the background
+// keep-alive.
+func (lk *Lock) Close() error {
+	lk.cancel()
+	_, err := lk.client.Revoke(context.Background(), lk.lease)
+	return err
+}
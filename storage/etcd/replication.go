@@ -0,0 +1,188 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/replication"
+)
+
+// ErrEventNotVersioned is when Replicate is given an event that doesn't
+// implement eventhorizon.Versionable, so there is no stable version to key
+// its replicated copy on.
+var ErrEventNotVersioned = errors.New("event does not implement Versionable")
+
+// LoadFrom implements replication.Source, giving the store a real tailing
+// cursor: it reads every event key under the store's prefix in etcd's own
+// mvcc modification order, which is a total order across all aggregates
+// without needing a separate index. namespace is accepted for interface
+// compatibility but unused, since this store, like the core
+// eventhorizon.EventStore interface it implements, has no namespace concept
+// of its own; EventStore deliberately does not implement
+// replication.NamespaceScoper, so Replicator rejects a namespace-scoped
+// Policy against it instead of silently replicating every namespace.
+func (s *EventStore) LoadFrom(namespace string, position replication.Position, limit int) ([]eh.Event, replication.Position, error) {
+	resp, err := s.client.Get(context.Background(), s.prefix+"/",
+		clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByModRevision, clientv3.SortAscend),
+		clientv3.WithMinModRev(int64(position)+1),
+		clientv3.WithLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, position, err
+	}
+
+	next := position
+	events := make([]eh.Event, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		if !isEventKey(string(kv.Key)) {
+			continue
+		}
+
+		var rec record
+		if err := json.Unmarshal(kv.Value, &rec); err != nil {
+			return nil, position, ErrCouldNotUnmarshalEvent
+		}
+
+		factory, ok := s.factory[rec.Type]
+		if !ok {
+			return nil, position, ErrEventNotRegistered
+		}
+
+		event := factory()
+		if err := json.Unmarshal(rec.Data, event); err != nil {
+			return nil, position, ErrCouldNotUnmarshalEvent
+		}
+		events = append(events, event)
+
+		if rev := replication.Position(kv.ModRevision); rev > next {
+			next = rev
+		}
+	}
+
+	return events, next, nil
+}
+
+// isEventKey reports whether key holds an event record rather than one of
+// the store's "_version" or "_index/..." bookkeeping keys.
+func isEventKey(key string) bool {
+	return !strings.HasSuffix(key, "/_version") && !strings.Contains(key, "/_index/")
+}
+
+// Replicate implements replication.TargetWriter. Every event must implement
+// eventhorizon.Versionable: its own AggregateVersion is used as the key the
+// event is written under, exactly as Save would have written it, so
+// re-running a replication round after a crash overwrites with identical
+// data instead of duplicating events. Each aggregate's events are committed
+// together with its version key bump in one transaction per aggregate.
+func (s *EventStore) Replicate(events []eh.Event) error {
+	type aggregate struct {
+		aggregateType eh.AggregateType
+		maxVersion    int
+		ops           []clientv3.Op
+	}
+	aggregates := map[eh.UUID]*aggregate{}
+	order := make([]eh.UUID, 0)
+
+	for _, event := range events {
+		v, ok := event.(eh.Versionable)
+		if !ok {
+			return ErrEventNotVersioned
+		}
+
+		data, err := json.Marshal(event)
+		if err != nil {
+			return ErrCouldNotMarshalEvent
+		}
+		rec, err := json.Marshal(record{Type: event.EventType(), Data: data})
+		if err != nil {
+			return ErrCouldNotMarshalEvent
+		}
+
+		id := event.AggregateID()
+		version := v.AggregateVersion()
+
+		a, ok := aggregates[id]
+		if !ok {
+			a = &aggregate{aggregateType: event.AggregateType()}
+			aggregates[id] = a
+			order = append(order, id)
+		}
+		a.ops = append(a.ops,
+			clientv3.OpPut(s.eventKey(event.AggregateType(), id, version), string(rec)),
+			clientv3.OpPut(s.indexKey(id), string(event.AggregateType())),
+		)
+		if version > a.maxVersion {
+			a.maxVersion = version
+		}
+	}
+
+	for _, id := range order {
+		a := aggregates[id]
+		if err := s.replicateAggregate(a.aggregateType, id, a.maxVersion, a.ops); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replicateAggregate commits ops together with a bump of the aggregate's
+// version key to max(existing, maxVersion), guarded by the same
+// optimistic-concurrency Compare Save uses against the version key's
+// current value, retrying if it is changed concurrently, for example by
+// another writer during a blue/green cutover.
+func (s *EventStore) replicateAggregate(aggregateType eh.AggregateType, id eh.UUID, maxVersion int, ops []clientv3.Op) error {
+	versionKey := s.versionKey(aggregateType, id)
+
+	for {
+		resp, err := s.client.Get(context.Background(), versionKey)
+		if err != nil {
+			return err
+		}
+
+		existing := 0
+		var cmp clientv3.Cmp
+		if len(resp.Kvs) == 0 {
+			cmp = clientv3.Compare(clientv3.CreateRevision(versionKey), "=", 0)
+		} else {
+			existing, _ = strconv.Atoi(string(resp.Kvs[0].Value))
+			cmp = clientv3.Compare(clientv3.Value(versionKey), "=", strconv.Itoa(existing))
+		}
+
+		version := maxVersion
+		if existing > version {
+			version = existing
+		}
+
+		txnOps := append(append([]clientv3.Op{}, ops...), clientv3.OpPut(versionKey, strconv.Itoa(version)))
+
+		txnResp, err := s.client.Txn(context.Background()).If(cmp).Then(txnOps...).Commit()
+		if err != nil {
+			return err
+		}
+		if txnResp.Succeeded {
+			return nil
+		}
+	}
+}
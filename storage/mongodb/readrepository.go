@@ -15,9 +15,11 @@
 package mongodb
 
 import (
+	"context"
 	"errors"
 
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 
 	"github.com/looplab/eventhorizon"
 )
@@ -25,12 +27,23 @@ import (
 // ErrModelNotSet is when an model is not set on a read repository.
 var ErrModelNotSet = errors.New("model not set")
 
+// ErrProjectionModelNotSet is when a projection model is not set on a read
+// repository but Aggregate is called.
+var ErrProjectionModelNotSet = errors.New("projection model not set")
+
+// VersionField is the top-level, indexed field that AggregateVersion is
+// written to, so that a minimum version lookup can be resolved with a
+// single server-side query instead of a fetch-then-check retry loop. See
+// FindMinVersion and EnsureIndexes.
+const VersionField = "aggregate_version"
+
 // ReadRepository implements an MongoDB repository of read models.
 type ReadRepository struct {
-	session    *mgo.Session
-	db         string
-	collection string
-	factory    func() interface{}
+	session           *mgo.Session
+	db                string
+	collection        string
+	factory           func() interface{}
+	projectionFactory func() interface{}
 }
 
 // NewReadRepository creates a new ReadRepository.
@@ -61,17 +74,69 @@ func NewReadRepositoryWithSession(session *mgo.Session, database, collection str
 	return r, nil
 }
 
-// Save saves a read model with id to the repository.
+// Save saves a read model with id to the repository's plain collection. Its
+// signature has no ctx, matching eventhorizon.ReadWriteRepo, so it cannot be
+// namespace-scoped; use SaveNamespaced for models that should be visible to
+// the namespace-aware FindByFilter, Aggregate and FindMinVersion. If model
+// implements eventhorizon.Versionable its version is additionally written to
+// VersionField, regardless of what the model's own bson tags are.
 func (r *ReadRepository) Save(id eventhorizon.UUID, model interface{}) error {
 	sess := r.session.Copy()
 	defer sess.Close()
 
-	if _, err := sess.DB(r.db).C(r.collection).UpsertId(id, model); err != nil {
+	doc, err := versionedDoc(model)
+	if err != nil {
+		return err
+	}
+
+	if _, err := sess.DB(r.db).C(r.collection).UpsertId(id, doc); err != nil {
+		return eventhorizon.ErrCouldNotSaveModel
+	}
+	return nil
+}
+
+// SaveNamespaced is the namespace-aware counterpart to Save: it writes into
+// the same per-namespace collection that FindByFilter, Aggregate and
+// FindMinVersion query, derived from the namespace carried on ctx. It is a
+// separate method, rather than a namespaced Save, because Save's signature
+// is fixed by eventhorizon.ReadWriteRepo and callers such as
+// replication.Replicator depend on that exact shape.
+func (r *ReadRepository) SaveNamespaced(ctx context.Context, id eventhorizon.UUID, model interface{}) error {
+	sess := r.session.Copy()
+	defer sess.Close()
+
+	doc, err := versionedDoc(model)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.namespaceCollection(ctx, sess).UpsertId(id, doc); err != nil {
 		return eventhorizon.ErrCouldNotSaveModel
 	}
 	return nil
 }
 
+// versionedDoc returns model as-is, unless it implements
+// eventhorizon.Versionable, in which case it returns a bson.M copy with its
+// version additionally written to VersionField.
+func versionedDoc(model interface{}) (interface{}, error) {
+	v, ok := model.(eventhorizon.Versionable)
+	if !ok {
+		return model, nil
+	}
+
+	raw, err := bson.Marshal(model)
+	if err != nil {
+		return nil, eventhorizon.ErrCouldNotSaveModel
+	}
+	m := bson.M{}
+	if err := bson.Unmarshal(raw, &m); err != nil {
+		return nil, eventhorizon.ErrCouldNotSaveModel
+	}
+	m[VersionField] = v.AggregateVersion()
+	return m, nil
+}
+
 // Find returns one read model with using an id. Returns
 // ErrModelNotFound if no model could be found.
 func (r *ReadRepository) Find(id eventhorizon.UUID) (interface{}, error) {
@@ -140,6 +205,241 @@ func (r *ReadRepository) FindAll() ([]interface{}, error) {
 	return result, nil
 }
 
+// namespaceCollection returns the collection the repository should query
+// or write to for ctx, scoping to a per-namespace collection when the
+// context carries one.
+func (r *ReadRepository) namespaceCollection(ctx context.Context, sess *mgo.Session) *mgo.Collection {
+	name := r.collection
+	if namespace := eventhorizon.NamespaceFromContext(ctx); namespace != "" {
+		name = r.collection + "_" + namespace
+	}
+	return sess.DB(r.db).C(name)
+}
+
+// FindByFilter uses a raw MongoDB filter to query the repository, scoping
+// the query to the namespace carried on ctx. It is a lower-ceremony
+// alternative to FindCustom for simple filters that don't need a custom
+// *mgo.Query.
+func (r *ReadRepository) FindByFilter(ctx context.Context, filter bson.M) ([]interface{}, error) {
+	sess := r.session.Copy()
+	defer sess.Close()
+
+	if r.factory == nil {
+		return nil, ErrModelNotSet
+	}
+
+	iter := r.namespaceCollection(ctx, sess).Find(filter).Iter()
+	result := []interface{}{}
+	model := r.factory()
+	for iter.Next(model) {
+		result = append(result, model)
+		model = r.factory()
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// SetProjectionModel sets a factory function that creates the concrete
+// model type Aggregate decodes its results into, kept separate from the
+// factory used by Find and friends since an aggregation pipeline commonly
+// projects into a different shape than the stored model.
+func (r *ReadRepository) SetProjectionModel(factory func() interface{}) {
+	r.projectionFactory = factory
+}
+
+// Aggregate runs an aggregation pipeline against the repository's
+// collection, scoped to the namespace carried on ctx, and decodes the
+// results using the factory set with SetProjectionModel.
+func (r *ReadRepository) Aggregate(ctx context.Context, pipeline []bson.M) ([]interface{}, error) {
+	sess := r.session.Copy()
+	defer sess.Close()
+
+	if r.projectionFactory == nil {
+		return nil, ErrProjectionModelNotSet
+	}
+
+	iter := r.namespaceCollection(ctx, sess).Pipe(pipeline).Iter()
+	result := []interface{}{}
+	model := r.projectionFactory()
+	for iter.Next(model) {
+		result = append(result, model)
+		model = r.projectionFactory()
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// FindMinVersion returns one read model with id, the same as Find, but
+// tries to resolve the minimum version check server-side with a single
+// filtered query on VersionField instead of always fetching the model and
+// checking its version in Go. It is used by repo/version.Repo to cut the
+// latency of its retry loop.
+//
+// VersionField is absent on documents saved before it was introduced, and
+// is never written for models that don't implement
+// eventhorizon.Versionable (see SaveNamespaced), so a plain "$gte" never
+// matches either of those; when the fast query comes back empty FindMinVersion
+// falls back to a plain lookup to tell a genuine version mismatch apart
+// from those two cases, matching the errors repo/version.Repo's own
+// in-Go check would have returned.
+func (r *ReadRepository) FindMinVersion(ctx context.Context, id eventhorizon.UUID, minVersion int) (interface{}, error) {
+	sess := r.session.Copy()
+	defer sess.Close()
+
+	if r.factory == nil {
+		return nil, ErrModelNotSet
+	}
+
+	namespace := eventhorizon.NamespaceFromContext(ctx)
+	collection := r.namespaceCollection(ctx, sess)
+
+	model := r.factory()
+	filter := bson.M{
+		"_id":        id,
+		VersionField: bson.M{"$gte": minVersion},
+	}
+	if err := collection.Find(filter).One(model); err == nil {
+		return model, nil
+	}
+
+	model = r.factory()
+	if err := collection.FindId(id).One(model); err != nil {
+		return nil, eventhorizon.RepoError{
+			Err:       eventhorizon.ErrModelNotFound,
+			Namespace: namespace,
+		}
+	}
+
+	versionable, ok := model.(eventhorizon.Versionable)
+	if !ok {
+		return nil, eventhorizon.RepoError{
+			Err:       eventhorizon.ErrModelHasNoVersion,
+			Namespace: namespace,
+		}
+	}
+
+	if versionable.AggregateVersion() < minVersion {
+		return nil, eventhorizon.RepoError{
+			Err:       eventhorizon.ErrIncorrectModelVersion,
+			Namespace: namespace,
+		}
+	}
+
+	// VersionField was missing or stale (for example on a document saved
+	// before it was introduced) but the model itself reports a sufficient
+	// version, so accept it.
+	return model, nil
+}
+
+// EnsureIndexes bootstraps the given indexes on the repository's
+// collection, for example an index on VersionField so FindMinVersion can
+// be resolved server-side. Indexes are created on the plain collection
+// only; the per-namespace collections that FindByFilter, Aggregate and
+// FindMinVersion use when ctx carries a namespace are not indexed.
+func (r *ReadRepository) EnsureIndexes(indexes []mgo.Index) error {
+	sess := r.session.Copy()
+	defer sess.Close()
+
+	collection := sess.DB(r.db).C(r.collection)
+	for _, index := range indexes {
+		if err := collection.EnsureIndex(index); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ChangeEvent is a single change observed by Watch.
+type ChangeEvent struct {
+	// OperationType is the MongoDB change stream operation type, for
+	// example "insert", "update", "replace" or "delete".
+	OperationType string
+
+	// DocumentKey identifies the changed document, usually {"_id": ...}.
+	DocumentKey bson.M
+
+	// FullDocument is the document as it looks after the change. It is
+	// nil for "delete" operations.
+	FullDocument interface{}
+}
+
+// Watch starts a MongoDB change stream over the repository's collection
+// filtered by filter, so that projectors can rebuild their read models
+// incrementally instead of re-reading the whole collection. The returned
+// channel is closed when ctx is cancelled.
+func (r *ReadRepository) Watch(ctx context.Context, filter bson.M) (<-chan ChangeEvent, error) {
+	if r.factory == nil {
+		return nil, ErrModelNotSet
+	}
+
+	sess := r.session.Copy()
+	collection := sess.DB(r.db).C(r.collection)
+
+	pipeline := []bson.M{{"$changeStream": bson.M{}}}
+	if filter != nil {
+		pipeline = append(pipeline, bson.M{"$match": filter})
+	}
+
+	iter := collection.Pipe(pipeline).Iter()
+
+	events := make(chan ChangeEvent)
+	go func() {
+		defer close(events)
+		defer sess.Close()
+		defer iter.Close()
+
+		for {
+			var raw struct {
+				OperationType string   `bson:"operationType"`
+				DocumentKey   bson.M   `bson:"documentKey"`
+				FullDocument  bson.Raw `bson:"fullDocument"`
+			}
+			for iter.Next(&raw) {
+				event := ChangeEvent{
+					OperationType: raw.OperationType,
+					DocumentKey:   raw.DocumentKey,
+				}
+
+				if raw.OperationType != "delete" {
+					model := r.factory()
+					if err := raw.FullDocument.Unmarshal(model); err == nil {
+						event.FullDocument = model
+					}
+				}
+
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			if iter.Timeout() {
+				continue
+			}
+
+			if err := iter.Err(); err != nil {
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
 // Remove removes a read model with id from the repository. Returns
 // ErrModelNotFound if no model could be found.
 func (r *ReadRepository) Remove(id eventhorizon.UUID) error {